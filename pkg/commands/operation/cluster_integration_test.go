@@ -0,0 +1,75 @@
+//go:build integration
+
+package operation
+
+// This file exercises redisCache against a real Redis Cluster via
+// dockertest. It's gated behind the "integration" build tag because it
+// needs a working Docker daemon; run it with:
+//
+//	go test -tags=integration ./pkg/commands/operation/... -run TestRedisCache_Cluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ory/dockertest/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aquasecurity/fanal/types"
+)
+
+// TestRedisCache_Cluster verifies that redisCache's single-key-per-command
+// design (see DeleteBlobs/Clear in rediscache.go) works against a real
+// multi-node Redis Cluster, where a command touching keys in more than one
+// hash slot would otherwise fail with a CROSSSLOT error.
+func TestRedisCache_Cluster(t *testing.T) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Skipf("docker not available: %s", err)
+	}
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository:   "grokzen/redis-cluster",
+		Tag:          "6.2.0",
+		ExposedPorts: []string{"7000", "7001", "7002", "7003", "7004", "7005"},
+		Env:          []string{"IP=0.0.0.0"},
+	})
+	if err != nil {
+		t.Skipf("unable to start redis cluster container: %s", err)
+	}
+	t.Cleanup(func() { _ = pool.Purge(resource) })
+
+	var client *redis.ClusterClient
+	err = pool.Retry(func() error {
+		addrs := make([]string, 6)
+		for i, port := range []string{"7000", "7001", "7002", "7003", "7004", "7005"} {
+			addrs[i] = fmt.Sprintf("localhost:%s", resource.GetPort(port+"/tcp"))
+		}
+		client = redis.NewClusterClient(&redis.ClusterOptions{Addrs: addrs})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return client.Ping(ctx).Err()
+	})
+	require.NoError(t, err, "redis cluster never became ready")
+	t.Cleanup(func() { _ = client.Close() })
+
+	c := newRedisCache(client, "", 0, 0)
+
+	// Blob IDs are arbitrary strings, so in a cluster they land on
+	// different hash slots; PutBlob/DeleteBlobs/Clear must handle that.
+	blobIDs := []string{"blob-a", "blob-b", "blob-c", "blob-d"}
+	for _, id := range blobIDs {
+		require.NoError(t, c.PutBlob(id, types.BlobInfo{SchemaVersion: 1}))
+	}
+
+	missingArtifact, missingBlobIDs, err := c.MissingBlobs("artifact-x", blobIDs)
+	require.NoError(t, err)
+	require.True(t, missingArtifact)
+	require.Empty(t, missingBlobIDs)
+
+	require.NoError(t, c.DeleteBlobs(blobIDs))
+	require.NoError(t, c.Clear())
+}
@@ -0,0 +1,353 @@
+package operation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/fulcio"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	"github.com/sigstore/cosign/v2/pkg/oci/static"
+	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/trivy/pkg/log"
+)
+
+// metadataFileName is the file whose detached cosign signature is checked
+// before a downloaded DB or policy bundle replaces what's in the cache.
+const metadataFileName = "metadata.json"
+
+// bundleSigSuffix names the cosign "sign-blob --bundle" output published
+// alongside metadataFileName and its ".sig": a JSON document embedding the
+// signing certificate and a signed entry timestamp from the public Rekor
+// log, so verifyMetadataSignature never has to reach Rekor over the network.
+const bundleSigSuffix = ".cosign.bundle"
+
+// defaultTrivyDBRepository is used for DB source URLs that don't name a
+// registry, e.g. a bare "ghcr://" or no sourceURL at all.
+const defaultTrivyDBRepository = "ghcr.io/aquasecurity/trivy-db"
+
+// ArtifactSource fetches a DB or policy bundle from a specific remote
+// location into a local directory, verifying its signature before use.
+// Implementations are resolved from the scheme of the source URL passed to
+// DownloadDB/InitBuiltinPolicies.
+type ArtifactSource interface {
+	// Fetch downloads the artifact into dir and verifies its signature.
+	Fetch(ctx context.Context, dir string) error
+}
+
+// NewArtifactSource resolves sourceURL into an ArtifactSource. An empty
+// sourceURL falls back to the default GHCR-hosted trivy-db repository.
+// Supported schemes:
+//
+//	ghcr://repo[:tag]          GitHub Container Registry
+//	oci://registry/repo[:tag]  any OCI registry, authenticated via the local docker config
+//	s3://bucket/prefix         AWS S3, using the default AWS credential chain
+//	gs://bucket/prefix         Google Cloud Storage, using Application Default Credentials
+//	https://host/path          a plain HTTPS mirror
+func NewArtifactSource(sourceURL string) (ArtifactSource, error) {
+	if sourceURL == "" {
+		return newOCISource(defaultTrivyDBRepository), nil
+	}
+
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to parse DB source URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "ghcr":
+		repo := u.Host + u.Path
+		if repo == "" {
+			return newOCISource(defaultTrivyDBRepository), nil
+		}
+		return newOCISource("ghcr.io/" + repo), nil
+	case "oci":
+		return newOCISource(u.Host + u.Path), nil
+	case "s3":
+		return newS3Source(u.Host, strings.TrimPrefix(u.Path, "/")), nil
+	case "gs":
+		return newGCSSource(u.Host, strings.TrimPrefix(u.Path, "/")), nil
+	case "http", "https":
+		return newHTTPSource(sourceURL), nil
+	default:
+		return nil, xerrors.Errorf("unsupported DB source scheme: %q", u.Scheme)
+	}
+}
+
+// ociSource fetches an artifact from an OCI registry, authenticated with the
+// local docker config (~/.docker/config.json), the same chain `docker
+// login` populates.
+type ociSource struct {
+	ref string // e.g. "ghcr.io/aquasecurity/trivy-db:2"
+}
+
+func newOCISource(ref string) *ociSource {
+	return &ociSource{ref: ref}
+}
+
+func (s *ociSource) Fetch(ctx context.Context, dir string) error {
+	log.Logger.Infof("Fetching %s from OCI registry...", s.ref)
+
+	img, err := crane.Pull(s.ref, crane.WithContext(ctx))
+	if err != nil {
+		return xerrors.Errorf("oci pull error (%s): %w", s.ref, err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return xerrors.Errorf("oci layers error (%s): %w", s.ref, err)
+	}
+	if len(layers) == 0 {
+		return xerrors.Errorf("oci image %s has no layers", s.ref)
+	}
+
+	// The db/policy bundle is published as a single layer containing
+	// metadata.json, metadata.json.sig, metadata.json.cosign.bundle and
+	// db.tar.gz; take the top layer in
+	// case the image was built with extra base layers underneath it.
+	rc, err := layers[len(layers)-1].Uncompressed()
+	if err != nil {
+		return xerrors.Errorf("oci layer read error (%s): %w", s.ref, err)
+	}
+	defer rc.Close()
+
+	if err := extractTar(rc, dir); err != nil {
+		return xerrors.Errorf("oci extract error (%s): %w", s.ref, err)
+	}
+
+	if err := verifyBundle(ctx, dir, s.ref); err != nil {
+		return err
+	}
+	return extractBundle(filepath.Join(dir, bundleName), dir)
+}
+
+// s3Source fetches an artifact from S3, using the default AWS credential
+// chain (environment, shared config, EC2/ECS/EKS instance role).
+type s3Source struct {
+	bucket, prefix string
+}
+
+func newS3Source(bucket, prefix string) *s3Source {
+	return &s3Source{bucket: bucket, prefix: prefix}
+}
+
+func (s *s3Source) Fetch(ctx context.Context, dir string) error {
+	log.Logger.Infof("Fetching s3://%s/%s...", s.bucket, s.prefix)
+	if err := s3Download(ctx, s.bucket, s.prefix, dir); err != nil {
+		return xerrors.Errorf("s3 download error: %w", err)
+	}
+
+	identity := s.bucket + "/" + s.prefix
+	if err := verifyBundle(ctx, dir, identity); err != nil {
+		return err
+	}
+	return extractBundle(filepath.Join(dir, bundleName), dir)
+}
+
+// gcsSource fetches an artifact from Google Cloud Storage, using Google's
+// Application Default Credentials chain so this just works inside GKE or
+// with GOOGLE_APPLICATION_CREDENTIALS set.
+type gcsSource struct {
+	bucket, prefix string
+}
+
+func newGCSSource(bucket, prefix string) *gcsSource {
+	return &gcsSource{bucket: bucket, prefix: prefix}
+}
+
+func (s *gcsSource) Fetch(ctx context.Context, dir string) error {
+	log.Logger.Infof("Fetching gs://%s/%s...", s.bucket, s.prefix)
+	if err := gcsDownload(ctx, s.bucket, s.prefix, dir); err != nil {
+		return xerrors.Errorf("gcs download error: %w", err)
+	}
+
+	identity := s.bucket + "/" + s.prefix
+	if err := verifyBundle(ctx, dir, identity); err != nil {
+		return err
+	}
+	return extractBundle(filepath.Join(dir, bundleName), dir)
+}
+
+// httpSource fetches a tarball from a plain HTTPS mirror, e.g. one served by
+// an internal artifact repository.
+type httpSource struct {
+	url string
+}
+
+func newHTTPSource(rawURL string) *httpSource {
+	return &httpSource{url: rawURL}
+}
+
+func (s *httpSource) Fetch(ctx context.Context, dir string) error {
+	log.Logger.Infof("Fetching %s...", s.url)
+	if err := httpDownload(ctx, s.url, dir); err != nil {
+		return xerrors.Errorf("http download error: %w", err)
+	}
+
+	if err := verifyBundle(ctx, dir, s.url); err != nil {
+		return err
+	}
+	return extractBundle(filepath.Join(dir, bundleName), dir)
+}
+
+// verifyMetadataSignature checks metadataPath's detached cosign signature
+// (stored alongside it as "metadata.json.sig", with the signing certificate
+// and Rekor inclusion proof in "metadata.json.cosign.bundle") against the
+// keyless Fulcio/Rekor transparency log before the caller is allowed to swap
+// the downloaded directory into the cache. identity must match a SAN on the
+// signing certificate, so a signature minted for one DB mirror can't be
+// replayed against another.
+func verifyMetadataSignature(ctx context.Context, metadataPath, identity string) error {
+	sigBytes, err := os.ReadFile(metadataPath + ".sig")
+	if err != nil {
+		return xerrors.Errorf("missing detached signature for %s: %w", metadataPath, err)
+	}
+
+	bundlePayload, err := cosign.FetchLocalSignedPayloadFromPath(metadataPath + bundleSigSuffix)
+	if err != nil {
+		return xerrors.Errorf("missing cosign bundle for %s: %w", metadataPath, err)
+	}
+	if bundlePayload.Cert == "" {
+		return xerrors.Errorf("cosign bundle for %s has no embedded certificate", metadataPath)
+	}
+
+	certPEM := []byte(bundlePayload.Cert)
+	if decoded, err := base64.StdEncoding.DecodeString(bundlePayload.Cert); err == nil {
+		certPEM = decoded
+	}
+
+	blob, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return xerrors.Errorf("unable to read %s: %w", metadataPath, err)
+	}
+
+	sig, err := static.NewSignature(blob, strings.TrimSpace(string(sigBytes)),
+		static.WithCertChain(certPEM, nil), static.WithBundle(bundlePayload.Bundle))
+	if err != nil {
+		return xerrors.Errorf("unable to build signature for %s: %w", metadataPath, err)
+	}
+
+	co, err := keylessCheckOpts(ctx, identity)
+	if err != nil {
+		return xerrors.Errorf("unable to build cosign verification options: %w", err)
+	}
+
+	if _, err := cosign.VerifyBlobSignature(ctx, sig, co); err != nil {
+		return xerrors.Errorf("cosign signature verification failed for %s: %w", identity, err)
+	}
+	return nil
+}
+
+// keylessCheckOpts builds the CheckOpts needed to verify a keyless cosign
+// signature entirely offline: the Fulcio root/intermediate certificates and
+// CT log keys bound the signing certificate's chain and embedded SCT, and
+// the Rekor public keys verify the bundle's signed entry timestamp, so no
+// network call to Rekor itself is needed at verification time.
+func keylessCheckOpts(ctx context.Context, identity string) (*cosign.CheckOpts, error) {
+	rootCerts, err := fulcio.GetRoots()
+	if err != nil {
+		return nil, xerrors.Errorf("unable to load Fulcio root certificates: %w", err)
+	}
+	intermediateCerts, err := fulcio.GetIntermediates()
+	if err != nil {
+		return nil, xerrors.Errorf("unable to load Fulcio intermediate certificates: %w", err)
+	}
+	ctLogPubKeys, err := cosign.GetCTLogPubs(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to load CT log public keys: %w", err)
+	}
+	rekorPubKeys, err := cosign.GetRekorPubs(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to load Rekor public keys: %w", err)
+	}
+
+	return &cosign.CheckOpts{
+		RootCerts:         rootCerts,
+		IntermediateCerts: intermediateCerts,
+		CTLogPubKeys:      ctLogPubKeys,
+		RekorPubKeys:      rekorPubKeys,
+		Identities:        []cosign.Identity{{Subject: identity}},
+	}, nil
+}
+
+// bundleMetadata is the subset of metadata.json that binds the
+// cosign-verified metadata to the bundle content shipped alongside it.
+type bundleMetadata struct {
+	Digest string `json:"digest"`
+}
+
+// verifyBundle checks metadata.json's cosign signature and then checks that
+// its declared digest matches the sha256 of bundleName in dir. cosign only
+// ever verifies metadata.json's own bytes, so without this an attacker who
+// swaps db.tar.gz for something else, without touching metadata.json at
+// all, would sail through signature verification untouched.
+func verifyBundle(ctx context.Context, dir, identity string) error {
+	metadataPath := filepath.Join(dir, metadataFileName)
+	if err := verifyMetadataSignature(ctx, metadataPath, identity); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return xerrors.Errorf("unable to read %s: %w", metadataPath, err)
+	}
+
+	var meta bundleMetadata
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return xerrors.Errorf("unable to parse %s: %w", metadataPath, err)
+	}
+	if meta.Digest == "" {
+		return xerrors.Errorf("%s does not declare a digest for %s, refusing to trust it", metadataPath, bundleName)
+	}
+
+	bundlePath := filepath.Join(dir, bundleName)
+	sum, err := fileSHA256(bundlePath)
+	if err != nil {
+		return xerrors.Errorf("unable to checksum %s: %w", bundlePath, err)
+	}
+
+	wantDigest := strings.TrimPrefix(meta.Digest, "sha256:")
+	if sum != wantDigest {
+		return xerrors.Errorf("%s digest mismatch: metadata.json declares %s, downloaded file hashes to %s", bundleName, wantDigest, sum)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// atomicSwap replaces dst with the contents of src via a rename, so readers
+// never observe a partially-written DB or policy directory.
+func atomicSwap(src, dst string) error {
+	old := dst + ".old"
+	_ = os.RemoveAll(old)
+
+	if _, err := os.Stat(dst); err == nil {
+		if err := os.Rename(dst, old); err != nil {
+			return xerrors.Errorf("unable to move the previous contents of %s aside: %w", dst, err)
+		}
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		// best-effort restore of the previous contents
+		_ = os.Rename(old, dst)
+		return xerrors.Errorf("unable to swap in %s: %w", src, err)
+	}
+
+	_ = os.RemoveAll(old)
+	return nil
+}
@@ -0,0 +1,273 @@
+package operation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofrs/flock"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/trivy/pkg/commands/option"
+	"github.com/aquasecurity/trivy/pkg/log"
+)
+
+const (
+	// defaultLockTimeout bounds how long a caller waits to acquire a
+	// download lock before giving up.
+	defaultLockTimeout = 5 * time.Minute
+
+	// softStaleWindow lets a caller whose local metadata only just expired
+	// proceed without waiting on the lock at all, instead of queuing
+	// behind whichever process is already downloading.
+	softStaleWindow = 1 * time.Hour
+
+	lockBackoffMin = 100 * time.Millisecond
+	lockBackoffMax = 5 * time.Second
+
+	// redisLockTTL is how long a redisLocker's lock survives without being
+	// renewed; redisLockRenewInterval, well under that, is how often the
+	// holder renews it so a download slower than redisLockTTL never loses
+	// the lock mid-transfer.
+	redisLockTTL           = 10 * time.Minute
+	redisLockRenewInterval = redisLockTTL / 3
+)
+
+// redisLockReleaseScript deletes a redisLocker's key only if it still holds
+// the token that key was acquired with, so releasing a lock this process
+// lost to expiry never deletes a different process's legitimately-held
+// lock on the same key.
+var redisLockReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// redisLockRenewScript refreshes a redisLocker's key TTL only if it still
+// holds the token that key was acquired with, for the same reason.
+var redisLockRenewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Locker coordinates exclusive access to a shared resource, such as the DB
+// cache directory, across multiple Trivy processes (CI runners, the
+// Kubernetes operator, trivy server instances) that point at the same
+// cache.
+type Locker interface {
+	// Lock blocks until the lock identified by key is acquired or timeout
+	// elapses, returning a function that releases it.
+	Lock(ctx context.Context, key string, timeout time.Duration) (unlock func(), err error)
+
+	// Close releases any connection the Locker holds open, e.g. the Redis
+	// client behind a redisLocker.
+	Close() error
+}
+
+// newLocker returns the Locker appropriate for the configured cache: a
+// Redis-backed lock for "redis://", "redis+cluster://" and
+// "redis+sentinel://" cacheBackend values, using the same TLS settings
+// NewCache derives from redisOption, otherwise a local flock under
+// cacheDir.
+func newLocker(cacheDir, cacheBackend string, redisOption option.RedisOption) Locker {
+	switch {
+	case strings.HasPrefix(cacheBackend, "redis+cluster://"):
+		addrs, err := parseRedisClusterURL(cacheBackend)
+		if err != nil {
+			log.Logger.Warnf("Unable to parse the Redis cluster cache backend for locking, falling back to a local lock: %s", err)
+			break
+		}
+		clusterOptions := &redis.ClusterOptions{Addrs: addrs}
+		if (option.RedisOption{}) != redisOption {
+			tlsConfig, err := redisTLSConfig(redisOption)
+			if err != nil {
+				log.Logger.Warnf("Unable to build TLS config for locking, falling back to a local lock: %s", err)
+				break
+			}
+			clusterOptions.TLSConfig = tlsConfig
+		}
+		return newRedisLocker(redis.NewClusterClient(clusterOptions))
+	case strings.HasPrefix(cacheBackend, "redis+sentinel://"):
+		sentinelAddrs, masterName, err := parseRedisSentinelURL(cacheBackend)
+		if err != nil {
+			log.Logger.Warnf("Unable to parse the Redis sentinel cache backend for locking, falling back to a local lock: %s", err)
+			break
+		}
+		failoverOptions := &redis.FailoverOptions{MasterName: masterName, SentinelAddrs: sentinelAddrs}
+		if (option.RedisOption{}) != redisOption {
+			tlsConfig, err := redisTLSConfig(redisOption)
+			if err != nil {
+				log.Logger.Warnf("Unable to build TLS config for locking, falling back to a local lock: %s", err)
+				break
+			}
+			failoverOptions.TLSConfig = tlsConfig
+		}
+		return newRedisLocker(redis.NewFailoverClient(failoverOptions))
+	case strings.HasPrefix(cacheBackend, "redis://"):
+		options, err := redis.ParseURL(cacheBackend)
+		if err != nil {
+			log.Logger.Warnf("Unable to parse the Redis cache backend for locking, falling back to a local lock: %s", err)
+			break
+		}
+		if (option.RedisOption{}) != redisOption {
+			tlsConfig, err := redisTLSConfig(redisOption)
+			if err != nil {
+				log.Logger.Warnf("Unable to build TLS config for locking, falling back to a local lock: %s", err)
+				break
+			}
+			options.TLSConfig = tlsConfig
+		}
+		return newRedisLocker(redis.NewClient(options))
+	}
+	return newFSLocker(cacheDir)
+}
+
+// fsLocker implements Locker with an flock(2)-based file lock, used for the
+// filesystem cache backend.
+type fsLocker struct {
+	dir string
+}
+
+func newFSLocker(dir string) *fsLocker {
+	return &fsLocker{dir: dir}
+}
+
+// Close is a no-op: fsLocker holds no open connection, each Lock call opens
+// and releases its own flock.
+func (l *fsLocker) Close() error {
+	return nil
+}
+
+func (l *fsLocker) Lock(ctx context.Context, key string, timeout time.Duration) (func(), error) {
+	if err := os.MkdirAll(l.dir, 0700); err != nil {
+		return nil, xerrors.Errorf("unable to create lock directory: %w", err)
+	}
+
+	fl := flock.New(filepath.Join(l.dir, key+".lock"))
+	if err := waitForLock(ctx, timeout, func() (bool, error) {
+		return fl.TryLock()
+	}); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := fl.Unlock(); err != nil {
+			log.Logger.Warnf("failed to release lock %q: %s", key, err)
+		}
+	}, nil
+}
+
+// redisLocker implements Locker with a "SET key value NX PX ttl" lock in
+// Redis, used when the cache is shared across hosts.
+type redisLocker struct {
+	client redis.UniversalClient
+}
+
+func newRedisLocker(client redis.UniversalClient) *redisLocker {
+	return &redisLocker{client: client}
+}
+
+func (l *redisLocker) Lock(ctx context.Context, key string, timeout time.Duration) (func(), error) {
+	redisKey := "trivy:lock:" + key
+	token := uuid.New().String()
+
+	if err := waitForLock(ctx, timeout, func() (bool, error) {
+		return l.client.SetNX(ctx, redisKey, token, redisLockTTL).Result()
+	}); err != nil {
+		return nil, err
+	}
+
+	// Renew the lock on a heartbeat well inside redisLockTTL so a critical
+	// section that runs longer than redisLockTTL (entirely plausible for a
+	// large DB download) never loses the lock mid-transfer.
+	stopRenew := make(chan struct{})
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+		ticker := time.NewTicker(redisLockRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := redisLockRenewScript.Run(context.Background(), l.client, []string{redisKey}, token, redisLockTTL.Milliseconds()).Err()
+				if err != nil {
+					log.Logger.Warnf("failed to renew redis lock %q: %s", key, err)
+				}
+			case <-stopRenew:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stopRenew)
+		<-renewDone
+
+		// Compare-and-delete: only remove redisKey if it still holds our
+		// token, so if we lost the lock to expiry and another process has
+		// since acquired it, we don't delete their lock out from under them.
+		if err := redisLockReleaseScript.Run(context.Background(), l.client, []string{redisKey}, token).Err(); err != nil {
+			log.Logger.Warnf("failed to release redis lock %q: %s", key, err)
+		}
+	}, nil
+}
+
+// Close closes the underlying Redis client. Callers must invoke this once
+// they're done with the Locker; newLocker opens a fresh client per call, so
+// failing to close it leaks a connection per DownloadDB/InitBuiltinPolicies
+// invocation.
+func (l *redisLocker) Close() error {
+	return l.client.Close()
+}
+
+// waitForLock repeatedly calls tryLock with exponential backoff until it
+// succeeds, the context is done, or timeout elapses.
+func waitForLock(ctx context.Context, timeout time.Duration, tryLock func() (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := lockBackoffMin
+	for {
+		acquired, err := tryLock()
+		if err != nil {
+			return xerrors.Errorf("unable to acquire lock: %w", err)
+		}
+		if acquired {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return xerrors.Errorf("timed out waiting for lock: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > lockBackoffMax {
+			backoff = lockBackoffMax
+		}
+	}
+}
+
+// withDownloadLock runs fn while holding the named download lock, so that
+// concurrent callers coalesce behind a single download. timeout bounds how
+// long to wait for the lock; a zero timeout falls back to
+// defaultLockTimeout.
+func withDownloadLock(ctx context.Context, locker Locker, key string, timeout time.Duration, fn func() error) error {
+	if timeout <= 0 {
+		timeout = defaultLockTimeout
+	}
+
+	unlock, err := locker.Lock(ctx, key, timeout)
+	if err != nil {
+		return xerrors.Errorf("failed to acquire %q lock: %w", key, err)
+	}
+	defer unlock()
+	return fn()
+}
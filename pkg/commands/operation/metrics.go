@@ -0,0 +1,21 @@
+package operation
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// cacheHitsTotal and cacheEvictionsTotal let operators of a long-running
+// Redis-backed trivy server see whether the TTL/size budget configured via
+// the cache backend URL's "?ttl=" and "?max_bytes=" query parameters (see
+// parseRedisCacheLimits) is sized correctly.
+var (
+	cacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trivy_cache_hits_total",
+		Help: "Total number of artifact/blob cache hits served from the Redis cache",
+	})
+	cacheEvictionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "trivy_cache_evictions_total",
+		Help: "Total number of Redis cache entries evicted to stay within the configured byte budget",
+	})
+)
@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/tls"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/wire"
@@ -33,28 +35,90 @@ type Cache struct {
 
 // NewCache is the factory method for Cache
 func NewCache(c option.CacheOption) (Cache, error) {
-	if strings.HasPrefix(c.CacheBackend, "redis://") {
+	switch {
+	case strings.HasPrefix(c.CacheBackend, "redis+cluster://"):
+		log.Logger.Infof("Redis cluster cache: %s", c.CacheBackend)
+		addrs, err := parseRedisClusterURL(c.CacheBackend)
+		if err != nil {
+			return Cache{}, xerrors.Errorf("invalid redis cluster URL: %w", err)
+		}
+
+		clusterOptions := &redis.ClusterOptions{Addrs: addrs}
+		if (option.RedisOption{}) != c.RedisOption {
+			tlsConfig, err := redisTLSConfig(c.RedisOption)
+			if err != nil {
+				return Cache{}, err
+			}
+			clusterOptions.TLSConfig = tlsConfig
+		}
+
+		ttl, maxBytes, err := parseRedisCacheLimits(c.CacheBackend)
+		if err != nil {
+			return Cache{}, xerrors.Errorf("invalid redis cluster URL: %w", err)
+		}
+
+		return Cache{Cache: newRedisCache(redis.NewClusterClient(clusterOptions), cacheNamespace(c.CacheBackend), ttl, maxBytes)}, nil
+	case strings.HasPrefix(c.CacheBackend, "redis+sentinel://"):
+		log.Logger.Infof("Redis sentinel cache: %s", c.CacheBackend)
+		sentinelAddrs, masterName, err := parseRedisSentinelURL(c.CacheBackend)
+		if err != nil {
+			return Cache{}, xerrors.Errorf("invalid redis sentinel URL: %w", err)
+		}
+
+		failoverOptions := &redis.FailoverOptions{
+			MasterName:    masterName,
+			SentinelAddrs: sentinelAddrs,
+		}
+		if (option.RedisOption{}) != c.RedisOption {
+			tlsConfig, err := redisTLSConfig(c.RedisOption)
+			if err != nil {
+				return Cache{}, err
+			}
+			failoverOptions.TLSConfig = tlsConfig
+		}
+
+		ttl, maxBytes, err := parseRedisCacheLimits(c.CacheBackend)
+		if err != nil {
+			return Cache{}, xerrors.Errorf("invalid redis sentinel URL: %w", err)
+		}
+
+		return Cache{Cache: newRedisCache(redis.NewFailoverClient(failoverOptions), cacheNamespace(c.CacheBackend), ttl, maxBytes)}, nil
+	case strings.HasPrefix(c.CacheBackend, "redis://"):
 		log.Logger.Infof("Redis cache: %s", c.CacheBackend)
 		options, err := redis.ParseURL(c.CacheBackend)
 		if err != nil {
 			return Cache{}, err
 		}
 
+		var tlsConfig *tls.Config
 		if (option.RedisOption{}) != c.RedisOption {
-			caCert, cert, err := utils.GetTLSConfig(c.RedisCACert, c.RedisCert, c.RedisKey)
+			tlsConfig, err = redisTLSConfig(c.RedisOption)
 			if err != nil {
 				return Cache{}, err
 			}
+			options.TLSConfig = tlsConfig
+		}
+
+		tracking, localCacheTTL, err := parseRedisTrackingParams(c.CacheBackend)
+		if err != nil {
+			return Cache{}, xerrors.Errorf("invalid redis cache URL: %w", err)
+		}
 
-			options.TLSConfig = &tls.Config{
-				RootCAs:      caCert,
-				Certificates: []tls.Certificate{cert},
-				MinVersion:   tls.VersionTLS12,
+		ttl, maxBytes, err := parseRedisCacheLimits(c.CacheBackend)
+		if err != nil {
+			return Cache{}, xerrors.Errorf("invalid redis cache URL: %w", err)
+		}
+
+		if tracking {
+			log.Logger.Infof("Redis client-side caching enabled, local TTL: %s", localCacheTTL)
+			client, err := newTrackingRedisClient(options, tlsConfig)
+			if err != nil {
+				return Cache{}, xerrors.Errorf("unable to initialize tracking redis cache: %w", err)
 			}
+			return Cache{Cache: newTrackingRedisCache(client, localCacheTTL, ttl, cacheNamespace(c.CacheBackend))}, nil
 		}
 
-		redisCache := cache.NewRedisCache(options)
-		return Cache{Cache: redisCache}, nil
+		return Cache{Cache: newRedisCache(redis.NewClient(options), cacheNamespace(c.CacheBackend), ttl, maxBytes)}, nil
 	}
 
 	// standalone mode
@@ -65,6 +129,20 @@ func NewCache(c option.CacheOption) (Cache, error) {
 	return Cache{Cache: fsCache}, nil
 }
 
+// redisTLSConfig builds a tls.Config from the given RedisOption
+func redisTLSConfig(o option.RedisOption) (*tls.Config, error) {
+	caCert, cert, err := utils.GetTLSConfig(o.RedisCACert, o.RedisCert, o.RedisKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		RootCAs:      caCert,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
 // Reset resets the cache
 func (c Cache) Reset() (err error) {
 	if err := c.ClearDB(); err != nil {
@@ -94,32 +172,150 @@ func (c Cache) ClearArtifacts() error {
 	return nil
 }
 
-// DownloadDB downloads the DB
-func DownloadDB(appVersion, cacheDir string, quiet, skipUpdate bool) error {
-	client := db.NewClient(cacheDir, quiet)
-	ctx := context.Background()
-	needsUpdate, err := client.NeedsUpdate(appVersion, skipUpdate)
+// DBDownloader downloads the vulnerability database, coalescing concurrent
+// downloads from other Trivy processes that share the same cache behind a
+// single Locker so only one of them pays the cost of the ~100MB transfer.
+type DBDownloader struct {
+	locker      Locker
+	client      *db.Client
+	source      ArtifactSource
+	cacheDir    string
+	lockTimeout time.Duration
+}
+
+// NewDBDownloader is the factory method for DBDownloader. cacheBackend picks
+// the lock implementation, matching the rules NewCache uses to pick a cache:
+// a "redis://", "redis+cluster://" or "redis+sentinel://" URL takes a
+// distributed lock (using redisOption for TLS, same as NewCache), anything
+// else falls back to a local flock in cacheDir. sourceURL selects where the
+// DB itself is fetched from (see NewArtifactSource); an empty sourceURL
+// keeps the default GitHub release flow. A zero lockTimeout falls back to
+// defaultLockTimeout.
+func NewDBDownloader(cacheDir, cacheBackend, sourceURL string, redisOption option.RedisOption, lockTimeout time.Duration, quiet bool) (*DBDownloader, error) {
+	d := &DBDownloader{
+		locker:      newLocker(cacheDir, cacheBackend, redisOption),
+		client:      db.NewClient(cacheDir, quiet),
+		cacheDir:    cacheDir,
+		lockTimeout: lockTimeout,
+	}
+
+	if sourceURL != "" {
+		source, err := NewArtifactSource(sourceURL)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid DB source: %w", err)
+		}
+		d.source = source
+	}
+	return d, nil
+}
+
+// Close releases the DBDownloader's lock connection. Callers must invoke
+// this once they're done with the downloader.
+func (d *DBDownloader) Close() error {
+	return d.locker.Close()
+}
+
+// Download downloads the DB if it needs an update. Callers whose local
+// metadata is within the soft stale window proceed immediately with the
+// existing DB rather than waiting on the lock; everyone else blocks until
+// they win the lock or time out.
+func (d *DBDownloader) Download(ctx context.Context, appVersion string, skipUpdate bool) error {
+	needsUpdate, err := d.client.NeedsUpdate(appVersion, skipUpdate)
 	if err != nil {
 		return xerrors.Errorf("database error: %w", err)
 	}
+	if !needsUpdate {
+		return nil
+	}
+
+	if d.withinSoftStaleWindow() {
+		log.Logger.Debug("DB metadata is within the soft stale window, skipping the download lock")
+		return nil
+	}
+
+	log.Logger.Info("Need to update DB")
+	return withDownloadLock(ctx, d.locker, "trivy-db", d.lockTimeout, func() error {
+		// Another process may have finished the download while we were
+		// waiting for the lock.
+		needsUpdate, err := d.client.NeedsUpdate(appVersion, skipUpdate)
+		if err != nil {
+			return xerrors.Errorf("database error: %w", err)
+		}
+		if !needsUpdate {
+			return nil
+		}
 
-	if needsUpdate {
-		log.Logger.Info("Need to update DB")
 		log.Logger.Info("Downloading DB...")
-		if err = client.Download(ctx, cacheDir); err != nil {
+		if err = d.download(ctx); err != nil {
 			return xerrors.Errorf("failed to download vulnerability DB: %w", err)
 		}
+		return nil
+	})
+}
+
+// download fetches the DB using the configured ArtifactSource, or falls
+// back to the built-in GitHub release client when none was configured.
+func (d *DBDownloader) download(ctx context.Context) error {
+	if d.source == nil {
+		return d.client.Download(ctx, d.cacheDir)
+	}
+
+	tmpDir, err := os.MkdirTemp(d.cacheDir, ".trivy-db-*")
+	if err != nil {
+		return xerrors.Errorf("unable to create a temporary download directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := d.source.Fetch(ctx, tmpDir); err != nil {
+		return err
+	}
+	return atomicSwap(tmpDir, filepath.Join(d.cacheDir, "db"))
+}
+
+// withinSoftStaleWindow reports whether the local DB metadata expired
+// recently enough that it's cheaper to keep using it than to wait for the
+// lock.
+func (d *DBDownloader) withinSoftStaleWindow() bool {
+	meta, err := metadata.NewClient(d.cacheDir).Get()
+	if err != nil {
+		return false
+	}
+	since := time.Since(meta.NextUpdate)
+	return since >= 0 && since < softStaleWindow
+}
+
+// DownloadDB downloads the DB. sourceURL selects where the DB is fetched
+// from (ghcr://, oci://, s3://, gs:// or https://); an empty sourceURL uses
+// the default GitHub release flow. lockTimeout bounds how long to wait for
+// the download lock; a zero lockTimeout falls back to defaultLockTimeout.
+func DownloadDB(appVersion, cacheDir, cacheBackend, sourceURL string, redisOption option.RedisOption, lockTimeout time.Duration, quiet, skipUpdate bool) error {
+	downloader, err := NewDBDownloader(cacheDir, cacheBackend, sourceURL, redisOption, lockTimeout, quiet)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := downloader.Close(); err != nil {
+			log.Logger.Warnf("failed to close the DB downloader: %s", err)
+		}
+	}()
+
+	if err := downloader.Download(context.Background(), appVersion, skipUpdate); err != nil {
+		return err
 	}
 
 	// for debug
-	if err = showDBInfo(cacheDir); err != nil {
+	if err := showDBInfo(cacheDir); err != nil {
 		return xerrors.Errorf("failed to show database info: %w", err)
 	}
 	return nil
 }
 
-// InitBuiltinPolicies downloads the built-in policies and loads them
-func InitBuiltinPolicies(ctx context.Context, cacheDir string, quiet, skipUpdate bool) ([]string, error) {
+// InitBuiltinPolicies downloads the built-in policies and loads them.
+// policySourceURL selects where the policy bundle is fetched from (see
+// NewArtifactSource); an empty policySourceURL uses the policy client's
+// default OCI registry. lockTimeout bounds how long to wait for the
+// download lock; a zero lockTimeout falls back to defaultLockTimeout.
+func InitBuiltinPolicies(ctx context.Context, cacheDir, cacheBackend, policySourceURL string, redisOption option.RedisOption, lockTimeout time.Duration, quiet, skipUpdate bool) ([]string, error) {
 	client, err := policy.NewClient(cacheDir, quiet)
 	if err != nil {
 		return nil, xerrors.Errorf("policy client error: %w", err)
@@ -135,8 +331,28 @@ func InitBuiltinPolicies(ctx context.Context, cacheDir string, quiet, skipUpdate
 
 	if needsUpdate {
 		log.Logger.Info("Need to update the built-in policies")
-		log.Logger.Info("Downloading the built-in policies...")
-		if err = client.DownloadBuiltinPolicies(ctx); err != nil {
+		locker := newLocker(cacheDir, cacheBackend, redisOption)
+		err = withDownloadLock(ctx, locker, "trivy-policies", lockTimeout, func() error {
+			// Another process may have finished the download while we were
+			// waiting for the lock.
+			stillNeedsUpdate, err := client.NeedsUpdate()
+			if err != nil {
+				return xerrors.Errorf("unable to check if built-in policies need to be updated: %w", err)
+			}
+			if !stillNeedsUpdate {
+				return nil
+			}
+
+			log.Logger.Info("Downloading the built-in policies...")
+			if policySourceURL == "" {
+				return client.DownloadBuiltinPolicies(ctx)
+			}
+			return downloadPoliciesFrom(ctx, policySourceURL, cacheDir)
+		})
+		if closeErr := locker.Close(); closeErr != nil {
+			log.Logger.Warnf("failed to close the policy locker: %s", closeErr)
+		}
+		if err != nil {
 			return nil, xerrors.Errorf("failed to download built-in policies: %w", err)
 		}
 	}
@@ -152,6 +368,27 @@ func InitBuiltinPolicies(ctx context.Context, cacheDir string, quiet, skipUpdate
 	return policyPaths, nil
 }
 
+// downloadPoliciesFrom fetches a policy bundle from sourceURL and atomically
+// swaps it into cacheDir's "policy" directory, so policy bundles can be
+// mirrored through the same OCI/S3/GCS/HTTPS sources as the vulnerability DB.
+func downloadPoliciesFrom(ctx context.Context, sourceURL, cacheDir string) error {
+	source, err := NewArtifactSource(sourceURL)
+	if err != nil {
+		return xerrors.Errorf("invalid policy source: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp(cacheDir, ".trivy-policy-*")
+	if err != nil {
+		return xerrors.Errorf("unable to create a temporary download directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := source.Fetch(ctx, tmpDir); err != nil {
+		return err
+	}
+	return atomicSwap(tmpDir, filepath.Join(cacheDir, "policy"))
+}
+
 func showDBInfo(cacheDir string) error {
 	m := metadata.NewClient(cacheDir)
 	meta, err := m.Get()
@@ -0,0 +1,141 @@
+package operation
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultLocalCacheTTL is used when "?tracking=1" is set without an explicit
+// "local_ttl" query parameter.
+const defaultLocalCacheTTL = 5 * time.Minute
+
+// parseRedisTrackingParams extracts the "tracking" and "local_ttl" query
+// parameters from a "redis://" cache backend URL, e.g.
+// "redis://localhost:6379?tracking=1&local_ttl=10m" enables RESP3
+// client-side caching with a 10 minute local TTL.
+func parseRedisTrackingParams(backend string) (bool, time.Duration, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return false, 0, xerrors.Errorf("unable to parse URL: %w", err)
+	}
+
+	query := u.Query()
+	if !query.Has("tracking") {
+		return false, 0, nil
+	}
+
+	tracking := query.Get("tracking") == "1" || query.Get("tracking") == "true"
+	if !tracking {
+		return false, 0, nil
+	}
+
+	localCacheTTL := defaultLocalCacheTTL
+	if raw := query.Get("local_ttl"); raw != "" {
+		localCacheTTL, err = time.ParseDuration(raw)
+		if err != nil {
+			return false, 0, xerrors.Errorf("invalid local_ttl: %w", err)
+		}
+	}
+
+	return true, localCacheTTL, nil
+}
+
+// cacheNamespace extracts the "?namespace=team-a" query parameter from a
+// cache backend URL, e.g. "redis://localhost:6379?namespace=team-a" scopes
+// every key the cache reads or writes to that namespace, the same way
+// "?tracking=1" opts into client-side caching. An empty result means the
+// cache isn't namespaced.
+func cacheNamespace(backend string) string {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("namespace")
+}
+
+// parseRedisCacheLimits extracts the "?ttl=" and "?max_bytes=" query
+// parameters from a cache backend URL, e.g.
+// "redis://localhost:6379?ttl=168h&max_bytes=536870912" expires entries
+// after 168h of inactivity (refreshed on every read) and evicts the
+// least-recently-used entries once the cache's approximate size passes
+// 512MiB. A zero result for either means that limit is disabled.
+func parseRedisCacheLimits(backend string) (time.Duration, int64, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return 0, 0, xerrors.Errorf("unable to parse URL: %w", err)
+	}
+	query := u.Query()
+
+	var ttl time.Duration
+	if raw := query.Get("ttl"); raw != "" {
+		ttl, err = time.ParseDuration(raw)
+		if err != nil {
+			return 0, 0, xerrors.Errorf("invalid ttl: %w", err)
+		}
+	}
+
+	var maxBytes int64
+	if raw := query.Get("max_bytes"); raw != "" {
+		maxBytes, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return 0, 0, xerrors.Errorf("invalid max_bytes: %w", err)
+		}
+	}
+
+	return ttl, maxBytes, nil
+}
+
+// parseRedisClusterURL parses a "redis+cluster://host1,host2,host3/" URL into
+// a list of cluster node addresses.
+func parseRedisClusterURL(backend string) ([]string, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to parse URL: %w", err)
+	}
+
+	hosts := strings.Split(u.Host, ",")
+	addrs := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if h == "" {
+			continue
+		}
+		addrs = append(addrs, h)
+	}
+
+	if len(addrs) == 0 {
+		return nil, xerrors.New("no cluster nodes found in URL")
+	}
+	return addrs, nil
+}
+
+// parseRedisSentinelURL parses a "redis+sentinel://sentinel1,sentinel2/master-name"
+// URL into the list of sentinel addresses and the master name.
+func parseRedisSentinelURL(backend string) ([]string, string, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, "", xerrors.Errorf("unable to parse URL: %w", err)
+	}
+
+	hosts := strings.Split(u.Host, ",")
+	addrs := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if h == "" {
+			continue
+		}
+		addrs = append(addrs, h)
+	}
+	if len(addrs) == 0 {
+		return nil, "", xerrors.New("no sentinel nodes found in URL")
+	}
+
+	masterName := strings.TrimPrefix(u.Path, "/")
+	if masterName == "" {
+		return nil, "", xerrors.New("master name is required, e.g. redis+sentinel://host1,host2/master-name")
+	}
+
+	return addrs, masterName, nil
+}
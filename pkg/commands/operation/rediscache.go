@@ -0,0 +1,322 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/fanal/types"
+	"github.com/aquasecurity/trivy/pkg/log"
+)
+
+// evictCheckInterval is how often the background trimmer in evictLoop
+// checks whether the cache is over its byte budget.
+const evictCheckInterval = 30 * time.Second
+
+// redisCache is a self-contained cache.Cache implementation backed by a
+// go-redis redis.UniversalClient, which is satisfied by *redis.Client,
+// *redis.ClusterClient (built from a "redis+cluster://" URL) and the
+// *redis.Client NewFailoverClient returns for "redis+sentinel://". Every
+// operation touches exactly one key at a time so it's safe to run against a
+// Redis Cluster: there's no multi-key command that could straddle two hash
+// slots.
+type redisCache struct {
+	client    redis.UniversalClient
+	namespace string
+
+	// ttl, when non-zero, is set on every entry and refreshed on every read
+	// (a sliding TTL), so entries that are still being used never expire
+	// but ones that have gone cold eventually do.
+	ttl time.Duration
+
+	// maxBytes, when non-zero, bounds the cache's approximate total size;
+	// evictLoop trims the least-recently-used entries once it's exceeded.
+	maxBytes  int64
+	stopEvict chan struct{}
+}
+
+// newRedisCache wraps client as a cache.Cache. A non-empty namespace scopes
+// every key this cache reads, writes or clears to "trivy:<namespace>:...",
+// so multiple tenants can share one Redis instance/cluster without
+// stepping on each other's keys or wiping one another's data on Clear. A
+// zero ttl never expires entries; a zero maxBytes never evicts them.
+func newRedisCache(client redis.UniversalClient, namespace string, ttl time.Duration, maxBytes int64) *redisCache {
+	c := &redisCache{client: client, namespace: namespace, ttl: ttl, maxBytes: maxBytes}
+	if maxBytes > 0 {
+		c.stopEvict = make(chan struct{})
+		go c.evictLoop()
+	}
+	return c
+}
+
+// keyPrefix is "trivy:" for an unnamespaced cache, or "trivy:<namespace>:"
+// otherwise.
+func (c *redisCache) keyPrefix() string {
+	if c.namespace == "" {
+		return "trivy:"
+	}
+	return fmt.Sprintf("trivy:%s:", c.namespace)
+}
+
+func (c *redisCache) key(kind, id string) string {
+	return fmt.Sprintf("%s%s:%s", c.keyPrefix(), kind, id)
+}
+
+// indexKey holds the sorted set of (key, lastAccess) pairs evictLoop reads
+// oldest-first when the cache is over its byte budget.
+func (c *redisCache) indexKey() string {
+	return c.keyPrefix() + "index"
+}
+
+// sizeKey holds a key -> approximate byte size hash, so evictLoop knows how
+// much to subtract from bytesKey when it evicts an entry.
+func (c *redisCache) sizeKey() string {
+	return c.keyPrefix() + "sizes"
+}
+
+// bytesKey holds a running total of the bytes tracked in sizeKey.
+func (c *redisCache) bytesKey() string {
+	return c.keyPrefix() + "bytes"
+}
+
+func (c *redisCache) MissingBlobs(artifactID string, blobIDs []string) (bool, []string, error) {
+	ctx := context.Background()
+
+	missingArtifact := false
+	if _, err := c.getRaw(ctx, c.key("artifact", artifactID)); err != nil {
+		if !xerrors.Is(err, redis.Nil) {
+			return false, nil, xerrors.Errorf("unable to check artifact %s: %w", artifactID, err)
+		}
+		missingArtifact = true
+	}
+
+	var missingBlobIDs []string
+	for _, blobID := range blobIDs {
+		if _, err := c.getRaw(ctx, c.key("blob", blobID)); err != nil {
+			if xerrors.Is(err, redis.Nil) {
+				missingBlobIDs = append(missingBlobIDs, blobID)
+				continue
+			}
+			return false, nil, xerrors.Errorf("unable to check blob %s: %w", blobID, err)
+		}
+	}
+	return missingArtifact, missingBlobIDs, nil
+}
+
+func (c *redisCache) PutArtifact(artifactID string, artifactInfo types.ArtifactInfo) error {
+	return c.put(context.Background(), c.key("artifact", artifactID), artifactInfo)
+}
+
+func (c *redisCache) PutBlob(blobID string, blobInfo types.BlobInfo) error {
+	return c.put(context.Background(), c.key("blob", blobID), blobInfo)
+}
+
+func (c *redisCache) GetArtifact(artifactID string) (types.ArtifactInfo, error) {
+	var info types.ArtifactInfo
+	err := c.get(context.Background(), c.key("artifact", artifactID), &info)
+	return info, err
+}
+
+func (c *redisCache) GetBlob(blobID string) (types.BlobInfo, error) {
+	var info types.BlobInfo
+	err := c.get(context.Background(), c.key("blob", blobID), &info)
+	return info, err
+}
+
+// DeleteBlobs removes each blob individually (rather than a single
+// multi-key DEL) so the command never touches more than one key, and so
+// never spans more than one Redis Cluster hash slot.
+func (c *redisCache) DeleteBlobs(blobIDs []string) error {
+	ctx := context.Background()
+	pipe := c.client.Pipeline()
+	for _, blobID := range blobIDs {
+		pipe.Del(ctx, c.key("blob", blobID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return xerrors.Errorf("unable to delete blobs: %w", err)
+	}
+	return nil
+}
+
+// Clear removes every key trivy wrote, by scanning for its keyPrefix one
+// page at a time rather than issuing FLUSHDB, so a shared Redis instance
+// isn't nuked wholesale. On a namespaced cache this only touches that
+// namespace's keys, leaving other tenants sharing the same instance alone.
+// On a Redis Cluster every master is scanned, since SCAN only walks the
+// keys of the node it runs on.
+func (c *redisCache) Clear() error {
+	ctx := context.Background()
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return c.clearNode(ctx, node)
+		})
+	}
+	return c.clearNode(ctx, c.client)
+}
+
+// clearNode deletes every key under keyPrefix that a SCAN on node finds.
+func (c *redisCache) clearNode(ctx context.Context, node redis.UniversalClient) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, c.keyPrefix()+"*", 100).Result()
+		if err != nil {
+			return xerrors.Errorf("unable to scan keys: %w", err)
+		}
+		for _, key := range keys {
+			if err := c.client.Del(ctx, key).Err(); err != nil {
+				return xerrors.Errorf("unable to delete %s: %w", key, err)
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// Close stops the background evictor, if one is running, and closes the
+// underlying Redis client.
+func (c *redisCache) Close() error {
+	if c.stopEvict != nil {
+		close(c.stopEvict)
+	}
+	return c.client.Close()
+}
+
+func (c *redisCache) put(ctx context.Context, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return xerrors.Errorf("unable to marshal %s: %w", key, err)
+	}
+	if err := c.client.Set(ctx, key, b, c.ttl).Err(); err != nil {
+		return xerrors.Errorf("unable to set %s: %w", key, err)
+	}
+	return c.trackSize(ctx, key, int64(len(b)))
+}
+
+func (c *redisCache) get(ctx context.Context, key string, v any) error {
+	b, err := c.getRaw(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return xerrors.Errorf("unable to unmarshal %s: %w", key, err)
+	}
+	return nil
+}
+
+// getRaw fetches key, refreshing its TTL (sliding expiry) and its position
+// in the LRU index on every hit, and bumping cacheHitsTotal.
+func (c *redisCache) getRaw(ctx context.Context, key string) ([]byte, error) {
+	b, err := c.client.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	cacheHitsTotal.Inc()
+
+	if c.ttl > 0 {
+		if err := c.client.Expire(ctx, key, c.ttl).Err(); err != nil {
+			log.Logger.Warnf("failed to refresh TTL for %s: %s", key, err)
+		}
+	}
+	if c.maxBytes > 0 {
+		if err := c.client.ZAdd(ctx, c.indexKey(), &redis.Z{Score: float64(time.Now().Unix()), Member: key}).Err(); err != nil {
+			log.Logger.Warnf("failed to refresh eviction index for %s: %s", key, err)
+		}
+	}
+	return b, nil
+}
+
+// trackSize records key's approximate size in the LRU index used by
+// evictLoop. It's a no-op when maxBytes is unset.
+func (c *redisCache) trackSize(ctx context.Context, key string, size int64) error {
+	if c.maxBytes <= 0 {
+		return nil
+	}
+
+	prevSize, err := c.client.HGet(ctx, c.sizeKey(), key).Int64()
+	if err != nil && !xerrors.Is(err, redis.Nil) {
+		return xerrors.Errorf("unable to read previous size for %s: %w", key, err)
+	}
+
+	pipe := c.client.Pipeline()
+	pipe.ZAdd(ctx, c.indexKey(), &redis.Z{Score: float64(time.Now().Unix()), Member: key})
+	pipe.HSet(ctx, c.sizeKey(), key, size)
+	pipe.IncrBy(ctx, c.bytesKey(), size-prevSize)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return xerrors.Errorf("unable to update the eviction index for %s: %w", key, err)
+	}
+	return nil
+}
+
+// evictLoop periodically trims the least-recently-used entries until the
+// cache is back within maxBytes. It's started by newRedisCache when
+// maxBytes is set, and stopped by Close.
+func (c *redisCache) evictLoop() {
+	ticker := time.NewTicker(evictCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.evictIfNeeded(context.Background()); err != nil {
+				log.Logger.Warnf("cache eviction failed: %s", err)
+			}
+		case <-c.stopEvict:
+			return
+		}
+	}
+}
+
+// evictIfNeeded removes the oldest entries in indexKey, one at a time,
+// until bytesKey reports the cache is within maxBytes or the index is
+// empty. Eviction is approximate: bytesKey and sizeKey are only as
+// accurate as the Put/Get calls that maintained them.
+func (c *redisCache) evictIfNeeded(ctx context.Context) error {
+	if c.maxBytes <= 0 {
+		return nil
+	}
+
+	for {
+		used, err := c.client.Get(ctx, c.bytesKey()).Int64()
+		if err != nil && !xerrors.Is(err, redis.Nil) {
+			return xerrors.Errorf("unable to read the cache size: %w", err)
+		}
+		if used <= c.maxBytes {
+			return nil
+		}
+
+		oldest, err := c.client.ZRangeWithScores(ctx, c.indexKey(), 0, 0).Result()
+		if err != nil {
+			return xerrors.Errorf("unable to read the eviction index: %w", err)
+		}
+		if len(oldest) == 0 {
+			return nil
+		}
+
+		member, ok := oldest[0].Member.(string)
+		if !ok {
+			return xerrors.Errorf("unexpected eviction index member type %T", oldest[0].Member)
+		}
+
+		size, err := c.client.HGet(ctx, c.sizeKey(), member).Int64()
+		if err != nil && !xerrors.Is(err, redis.Nil) {
+			return xerrors.Errorf("unable to read the size of %s: %w", member, err)
+		}
+
+		pipe := c.client.Pipeline()
+		pipe.Del(ctx, member)
+		pipe.ZRem(ctx, c.indexKey(), member)
+		pipe.HDel(ctx, c.sizeKey(), member)
+		pipe.DecrBy(ctx, c.bytesKey(), size)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return xerrors.Errorf("unable to evict %s: %w", member, err)
+		}
+		cacheEvictionsTotal.Inc()
+	}
+}
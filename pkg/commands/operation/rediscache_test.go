@@ -0,0 +1,146 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aquasecurity/fanal/types"
+)
+
+func newTestRedisCache(t *testing.T) (*redisCache, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return newRedisCache(client, "", 0, 0), mr
+}
+
+func TestRedisCache_PutGetBlob(t *testing.T) {
+	c, _ := newTestRedisCache(t)
+
+	blob := types.BlobInfo{SchemaVersion: 1}
+	require.NoError(t, c.PutBlob("blob-1", blob))
+
+	got, err := c.GetBlob("blob-1")
+	require.NoError(t, err)
+	assert.Equal(t, blob, got)
+}
+
+func TestRedisCache_PutGetArtifact(t *testing.T) {
+	c, _ := newTestRedisCache(t)
+
+	artifact := types.ArtifactInfo{SchemaVersion: 1}
+	require.NoError(t, c.PutArtifact("artifact-1", artifact))
+
+	got, err := c.GetArtifact("artifact-1")
+	require.NoError(t, err)
+	assert.Equal(t, artifact, got)
+}
+
+func TestRedisCache_MissingBlobs(t *testing.T) {
+	c, _ := newTestRedisCache(t)
+
+	require.NoError(t, c.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 1}))
+
+	missingArtifact, missingBlobIDs, err := c.MissingBlobs("artifact-1", []string{"blob-1", "blob-2"})
+	require.NoError(t, err)
+	assert.True(t, missingArtifact)
+	assert.Equal(t, []string{"blob-2"}, missingBlobIDs)
+}
+
+func TestRedisCache_DeleteBlobs(t *testing.T) {
+	c, _ := newTestRedisCache(t)
+
+	require.NoError(t, c.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 1}))
+	require.NoError(t, c.DeleteBlobs([]string{"blob-1"}))
+
+	_, err := c.GetBlob("blob-1")
+	assert.ErrorIs(t, err, redis.Nil)
+}
+
+func TestRedisCache_ClearOnlyTouchesTrivyKeys(t *testing.T) {
+	c, mr := newTestRedisCache(t)
+
+	require.NoError(t, c.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 1}))
+	require.NoError(t, mr.Set("unrelated:key", "untouched"))
+
+	require.NoError(t, c.Clear())
+
+	assert.False(t, mr.Exists("trivy:blob:blob-1"))
+	assert.True(t, mr.Exists("unrelated:key"))
+}
+
+func TestRedisCache_NamespaceIsolation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	tenantA := newRedisCache(client, "tenant-a", 0, 0)
+	tenantB := newRedisCache(client, "tenant-b", 0, 0)
+
+	require.NoError(t, tenantA.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 1}))
+	require.NoError(t, tenantB.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 2}))
+
+	// Clearing tenant-a must not touch tenant-b's copy of the same blob ID.
+	require.NoError(t, tenantA.Clear())
+
+	_, err := tenantA.GetBlob("blob-1")
+	assert.ErrorIs(t, err, redis.Nil)
+
+	got, err := tenantB.GetBlob("blob-1")
+	require.NoError(t, err)
+	assert.Equal(t, types.BlobInfo{SchemaVersion: 2}, got)
+}
+
+func TestRedisCache_TTLRefreshedOnRead(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	c := newRedisCache(client, "", time.Minute, 0)
+	require.NoError(t, c.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 1}))
+
+	mr.FastForward(50 * time.Second)
+	_, err := c.GetBlob("blob-1")
+	require.NoError(t, err)
+
+	// The read above should have reset the TTL back to a minute, so
+	// advancing past the original deadline must not expire the entry.
+	mr.FastForward(50 * time.Second)
+	_, err = c.GetBlob("blob-1")
+	require.NoError(t, err)
+}
+
+func TestRedisCache_EvictsOldestWhenOverBudget(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	// maxBytes is set but evictLoop's background ticker is never exercised
+	// here; evictIfNeeded is called directly so the test isn't tied to
+	// evictCheckInterval's real-world timing. The budget holds exactly one
+	// blob, so storing two puts the cache one blob over it.
+	blobBytes, err := json.Marshal(types.BlobInfo{SchemaVersion: 1})
+	require.NoError(t, err)
+	c := newRedisCache(client, "", 0, int64(len(blobBytes)))
+	close(c.stopEvict)
+	c.stopEvict = nil
+
+	require.NoError(t, c.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 1}))
+	require.NoError(t, c.PutBlob("blob-2", types.BlobInfo{SchemaVersion: 1}))
+
+	require.NoError(t, c.evictIfNeeded(context.Background()))
+
+	_, err = c.GetBlob("blob-1")
+	assert.ErrorIs(t, err, redis.Nil)
+
+	_, err = c.GetBlob("blob-2")
+	require.NoError(t, err)
+}
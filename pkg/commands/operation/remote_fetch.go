@@ -0,0 +1,216 @@
+package operation
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/xerrors"
+)
+
+// bundleName is the tarball object name each remote source stores next to
+// metadata.json.
+const bundleName = "db.tar.gz"
+
+// s3Download downloads metadata.json, its signature, its cosign bundle and
+// the db bundle from an S3 bucket using the default AWS credential chain
+// (environment, shared config, EC2/ECS/EKS instance role), leaving them in
+// dir unextracted so the caller can verify them before trusting their
+// contents.
+func s3Download(ctx context.Context, bucket, prefix, dir string) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return xerrors.Errorf("unable to load AWS config: %w", err)
+	}
+	downloader := manager.NewDownloader(s3.NewFromConfig(cfg))
+
+	for _, name := range []string{metadataFileName, metadataFileName + ".sig", metadataFileName + bundleSigSuffix, bundleName} {
+		if err := s3DownloadFile(ctx, downloader, bucket, filepath.Join(prefix, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func s3DownloadFile(ctx context.Context, downloader *manager.Downloader, bucket, key, dst string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return xerrors.Errorf("unable to create %s: %w", dst, err)
+	}
+	defer f.Close()
+
+	_, err = downloader.Download(ctx, f, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return xerrors.Errorf("unable to download s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// gcsDownload downloads metadata.json, its signature, its cosign bundle and
+// the db bundle from a GCS bucket, using Google's Application Default
+// Credentials chain, leaving them in dir unextracted so the caller can
+// verify them before trusting their contents.
+func gcsDownload(ctx context.Context, bucket, prefix, dir string) error {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return xerrors.Errorf("unable to create GCS client: %w", err)
+	}
+	defer client.Close()
+
+	for _, name := range []string{metadataFileName, metadataFileName + ".sig", metadataFileName + bundleSigSuffix, bundleName} {
+		if err := gcsDownloadFile(ctx, client, bucket, filepath.Join(prefix, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func gcsDownloadFile(ctx context.Context, client *storage.Client, bucket, object, dst string) error {
+	r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return xerrors.Errorf("unable to read gs://%s/%s: %w", bucket, object, err)
+	}
+	defer r.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return xerrors.Errorf("unable to create %s: %w", dst, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return xerrors.Errorf("unable to write %s: %w", dst, err)
+	}
+	return nil
+}
+
+// httpDownload downloads the db bundle tarball from a plain HTTPS mirror and
+// expects "metadata.json", "metadata.json.sig" and
+// "metadata.json.cosign.bundle" alongside it, e.g.
+// https://host/path/metadata.json and https://host/path/db.tar.gz, leaving
+// them in dir unextracted so the caller can verify them before trusting
+// their contents.
+func httpDownload(ctx context.Context, baseURL, dir string) error {
+	for _, name := range []string{metadataFileName, metadataFileName + ".sig", metadataFileName + bundleSigSuffix, bundleName} {
+		if err := httpDownloadFile(ctx, baseURL+"/"+name, filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func httpDownloadFile(ctx context.Context, url, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return xerrors.Errorf("unable to build request for %s: %w", url, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("unable to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return xerrors.Errorf("unable to create %s: %w", dst, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return xerrors.Errorf("unable to write %s: %w", dst, err)
+	}
+	return nil
+}
+
+// extractBundle unpacks a gzip-compressed tarball into dir.
+func extractBundle(tarGzPath, dir string) error {
+	f, err := os.Open(tarGzPath)
+	if err != nil {
+		return xerrors.Errorf("unable to open %s: %w", tarGzPath, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return xerrors.Errorf("unable to read gzip %s: %w", tarGzPath, err)
+	}
+	defer gz.Close()
+
+	if err := extractTar(gz, dir); err != nil {
+		return xerrors.Errorf("unable to extract %s: %w", tarGzPath, err)
+	}
+	return os.Remove(tarGzPath)
+}
+
+// extractTar writes the contents of tar stream r into dir. Every entry name
+// is resolved through safeJoin, so a tarball with an absolute path or a
+// "../" entry can't write outside dir (zip-slip) even if it came from a
+// compromised or MITM'd mirror.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return xerrors.Errorf("unable to read tar entry: %w", err)
+		}
+
+		target, err := safeJoin(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		if hdr.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return xerrors.Errorf("unable to create %s: %w", filepath.Dir(target), err)
+		}
+
+		out, err := os.Create(target)
+		if err != nil {
+			return xerrors.Errorf("unable to create %s: %w", target, err)
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return xerrors.Errorf("unable to write %s: %w", target, err)
+		}
+		out.Close()
+	}
+	return nil
+}
+
+// safeJoin joins dir and name the way filepath.Join would, but rejects the
+// result if it would land outside dir, e.g. a name with "../" segments that
+// escape the destination. An absolute name is joined under dir like any
+// other name, so it never lands outside dir.
+func safeJoin(dir, name string) (string, error) {
+	target := filepath.Join(dir, name)
+	if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+		return "", xerrors.Errorf("tar entry %q escapes destination directory", name)
+	}
+	return target, nil
+}
@@ -0,0 +1,227 @@
+package operation
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/redis/rueidis"
+	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/fanal/types"
+	"github.com/aquasecurity/trivy/pkg/log"
+)
+
+// trackingRedisCache is a cache.Cache implementation built on rueidis rather
+// than go-redis. Reads go through DoCache, which opts the connection into
+// RESP3 server-assisted client-side caching (CLIENT TRACKING): rueidis keeps
+// the result in an in-process cache and Redis pushes an invalidation message
+// when the key changes, so repeated GetBlob/GetArtifact lookups for the same
+// image layers across a multi-image scan are served locally instead of
+// round-tripping to Redis every time.
+type trackingRedisCache struct {
+	client        rueidis.Client
+	localCacheTTL time.Duration
+	namespace     string
+
+	// ttl, when non-zero, is set on every entry server-side and refreshed
+	// on every read (a sliding TTL), same as redisCache.ttl. This is
+	// independent of localCacheTTL, which only bounds how long rueidis
+	// trusts its own in-process copy.
+	ttl time.Duration
+}
+
+// newTrackingRedisCache wraps client as a cache.Cache, serving reads from
+// rueidis's client-side cache for up to localCacheTTL before falling back to
+// a server round trip. A non-empty namespace scopes every key this cache
+// reads, writes or clears to "trivy:<namespace>:...", matching redisCache. A
+// zero ttl never expires entries server-side.
+//
+// Size-bounded eviction isn't implemented here: redisCache's approximate
+// LRU/size index (see evictLoop in rediscache.go) is built on go-redis
+// commands this package already relies on elsewhere; duplicating it onto
+// rueidis's builder API wasn't worth the risk of getting an unfamiliar
+// command surface wrong for a cache mode most deployments won't combine
+// with a byte budget in the first place.
+func newTrackingRedisCache(client rueidis.Client, localCacheTTL, ttl time.Duration, namespace string) *trackingRedisCache {
+	return &trackingRedisCache{client: client, localCacheTTL: localCacheTTL, namespace: namespace, ttl: ttl}
+}
+
+// newTrackingRedisClient builds the rueidis.Client used by
+// newTrackingRedisCache from the same *redis.Options NewCache already parsed
+// out of the "redis://" URL, so TLS and auth settings stay in sync with the
+// non-tracking path.
+func newTrackingRedisClient(redisOptions *redis.Options, tlsConfig *tls.Config) (rueidis.Client, error) {
+	client, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress: []string{redisOptions.Addr},
+		Username:    redisOptions.Username,
+		Password:    redisOptions.Password,
+		SelectDB:    redisOptions.DB,
+		TLSConfig:   tlsConfig,
+		// NOLOOP stops the server from pushing this connection its own
+		// invalidations: without it, every write this client makes (e.g.
+		// getRaw's TTL-refreshing EXPIRE) invalidates the key it just
+		// cached via DoCache, turning every read into a cache miss.
+		ClientTrackingOptions: []string{"OPTIN", "NOLOOP"},
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("unable to initialize rueidis client: %w", err)
+	}
+	return client, nil
+}
+
+func (c *trackingRedisCache) keyPrefix() string {
+	if c.namespace == "" {
+		return "trivy:"
+	}
+	return fmt.Sprintf("trivy:%s:", c.namespace)
+}
+
+func (c *trackingRedisCache) key(kind, id string) string {
+	return fmt.Sprintf("%s%s:%s", c.keyPrefix(), kind, id)
+}
+
+func (c *trackingRedisCache) MissingBlobs(artifactID string, blobIDs []string) (bool, []string, error) {
+	ctx := context.Background()
+
+	missingArtifact := false
+	if _, err := c.getRaw(ctx, c.key("artifact", artifactID)); err != nil {
+		if !rueidis.IsRedisNil(err) {
+			return false, nil, xerrors.Errorf("unable to check artifact %s: %w", artifactID, err)
+		}
+		missingArtifact = true
+	}
+
+	var missingBlobIDs []string
+	for _, blobID := range blobIDs {
+		if _, err := c.getRaw(ctx, c.key("blob", blobID)); err != nil {
+			if rueidis.IsRedisNil(err) {
+				missingBlobIDs = append(missingBlobIDs, blobID)
+				continue
+			}
+			return false, nil, xerrors.Errorf("unable to check blob %s: %w", blobID, err)
+		}
+	}
+	return missingArtifact, missingBlobIDs, nil
+}
+
+func (c *trackingRedisCache) PutArtifact(artifactID string, artifactInfo types.ArtifactInfo) error {
+	return c.put(context.Background(), c.key("artifact", artifactID), artifactInfo)
+}
+
+func (c *trackingRedisCache) PutBlob(blobID string, blobInfo types.BlobInfo) error {
+	return c.put(context.Background(), c.key("blob", blobID), blobInfo)
+}
+
+func (c *trackingRedisCache) GetArtifact(artifactID string) (types.ArtifactInfo, error) {
+	var info types.ArtifactInfo
+	err := c.get(context.Background(), c.key("artifact", artifactID), &info)
+	return info, err
+}
+
+func (c *trackingRedisCache) GetBlob(blobID string) (types.BlobInfo, error) {
+	var info types.BlobInfo
+	err := c.get(context.Background(), c.key("blob", blobID), &info)
+	return info, err
+}
+
+// DeleteBlobs removes each blob with its own DEL, matching redisCache's
+// single-key-per-command rule so the same code still behaves if it's ever
+// pointed at a cluster in front of rueidis.
+func (c *trackingRedisCache) DeleteBlobs(blobIDs []string) error {
+	ctx := context.Background()
+	for _, blobID := range blobIDs {
+		cmd := c.client.B().Del().Key(c.key("blob", blobID)).Build()
+		if err := c.client.Do(ctx, cmd).Error(); err != nil {
+			return xerrors.Errorf("unable to delete blob %s: %w", blobID, err)
+		}
+	}
+	return nil
+}
+
+// Clear removes every key trivy wrote, by scanning for its keyPrefix one
+// page at a time rather than issuing FLUSHDB. On a namespaced cache this
+// only touches that namespace's keys.
+func (c *trackingRedisCache) Clear() error {
+	ctx := context.Background()
+
+	var cursor uint64
+	for {
+		cmd := c.client.B().Scan().Cursor(cursor).Match(c.keyPrefix() + "*").Count(100).Build()
+		entry, err := c.client.Do(ctx, cmd).AsScanEntry()
+		if err != nil {
+			return xerrors.Errorf("unable to scan keys: %w", err)
+		}
+		for _, key := range entry.Elements {
+			delCmd := c.client.B().Del().Key(key).Build()
+			if err := c.client.Do(ctx, delCmd).Error(); err != nil {
+				return xerrors.Errorf("unable to delete %s: %w", key, err)
+			}
+		}
+		cursor = entry.Cursor
+		if cursor == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *trackingRedisCache) Close() error {
+	c.client.Close()
+	return nil
+}
+
+func (c *trackingRedisCache) put(ctx context.Context, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return xerrors.Errorf("unable to marshal %s: %w", key, err)
+	}
+
+	builder := c.client.B().Set().Key(key).Value(rueidis.BinaryString(b))
+	var cmd rueidis.Completed
+	if c.ttl > 0 {
+		cmd = builder.ExSeconds(int64(c.ttl.Seconds())).Build()
+	} else {
+		cmd = builder.Build()
+	}
+	if err := c.client.Do(ctx, cmd).Error(); err != nil {
+		return xerrors.Errorf("unable to set %s: %w", key, err)
+	}
+	return nil
+}
+
+func (c *trackingRedisCache) get(ctx context.Context, key string, v any) error {
+	b, err := c.getRaw(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return xerrors.Errorf("unable to unmarshal %s: %w", key, err)
+	}
+	return nil
+}
+
+// getRaw issues the GET through DoCache so rueidis serves it from its
+// client-side cache, when a cached entry is still within localCacheTTL,
+// instead of making a round trip to Redis. Every successful read, whether
+// served locally or by Redis, also refreshes key's server-side TTL and
+// bumps cacheHitsTotal.
+func (c *trackingRedisCache) getRaw(ctx context.Context, key string) ([]byte, error) {
+	cmd := c.client.B().Get().Key(key).Cache()
+	b, err := c.client.DoCache(ctx, cmd, c.localCacheTTL).AsBytes()
+	if err != nil {
+		return nil, err
+	}
+	cacheHitsTotal.Inc()
+
+	if c.ttl > 0 {
+		expireCmd := c.client.B().Expire().Key(key).Seconds(int64(c.ttl.Seconds())).Build()
+		if err := c.client.Do(ctx, expireCmd).Error(); err != nil {
+			log.Logger.Warnf("failed to refresh TTL for %s: %s", key, err)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,157 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aquasecurity/fanal/types"
+)
+
+func newTestTrackingRedisCache(t *testing.T) (*trackingRedisCache, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	client, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{mr.Addr()}})
+	if err != nil {
+		t.Skipf("rueidis client unavailable against miniredis: %s", err)
+	}
+	t.Cleanup(client.Close)
+
+	return newTrackingRedisCache(client, time.Minute, 0, ""), mr
+}
+
+func TestTrackingRedisCache_PutGetBlob(t *testing.T) {
+	c, _ := newTestTrackingRedisCache(t)
+
+	blob := types.BlobInfo{SchemaVersion: 1}
+	require.NoError(t, c.PutBlob("blob-1", blob))
+
+	got, err := c.GetBlob("blob-1")
+	require.NoError(t, err)
+	assert.Equal(t, blob, got)
+}
+
+func TestTrackingRedisCache_MissingBlobs(t *testing.T) {
+	c, _ := newTestTrackingRedisCache(t)
+
+	require.NoError(t, c.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 1}))
+
+	missingArtifact, missingBlobIDs, err := c.MissingBlobs("artifact-1", []string{"blob-1", "blob-2"})
+	require.NoError(t, err)
+	assert.True(t, missingArtifact)
+	assert.Equal(t, []string{"blob-2"}, missingBlobIDs)
+}
+
+func TestTrackingRedisCache_ClearOnlyTouchesTrivyKeys(t *testing.T) {
+	c, mr := newTestTrackingRedisCache(t)
+
+	require.NoError(t, c.PutBlob("blob-1", types.BlobInfo{SchemaVersion: 1}))
+	require.NoError(t, mr.Set("unrelated:key", "untouched"))
+
+	require.NoError(t, c.Clear())
+
+	assert.False(t, mr.Exists("trivy:blob:blob-1"))
+	assert.True(t, mr.Exists("unrelated:key"))
+}
+
+// TestTrackingRedisCache_NoLoopKeepsLocalCacheOnOwnWrite guards against a
+// regression of the bug where newTrackingRedisClient's default tracking
+// options (no NOLOOP) made the client invalidate its own local cache entry
+// every time getRaw refreshed a key's TTL, turning ttl > 0 client-side
+// caching into strictly more round trips than the plain redisCache path.
+func TestTrackingRedisCache_NoLoopKeepsLocalCacheOnOwnWrite(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client, err := newTrackingRedisClient(&redis.Options{Addr: mr.Addr()}, nil)
+	if err != nil {
+		t.Skipf("rueidis client unavailable against miniredis: %s", err)
+	}
+	t.Cleanup(client.Close)
+
+	ctx := context.Background()
+	key := "trivy:blob:ttl-test"
+	require.NoError(t, client.Do(ctx, client.B().Set().Key(key).Value("v").Build()).Error())
+
+	getCmd := client.B().Get().Key(key).Cache()
+	require.NoError(t, client.DoCache(ctx, getCmd, time.Minute).Error())
+
+	// The same TTL-refreshing EXPIRE getRaw issues on every read.
+	expireCmd := client.B().Expire().Key(key).Seconds(60).Build()
+	require.NoError(t, client.Do(ctx, expireCmd).Error())
+
+	second := client.DoCache(ctx, getCmd, time.Minute)
+	require.NoError(t, second.Error())
+	assert.True(t, second.IsCacheHit(), "the client's own EXPIRE must not invalidate its local cache entry")
+}
+
+// benchmarkLayerRescan simulates a re-scan of an image made of layerCount
+// layers, all of which are already cached: for every layer it calls get
+// (GetBlob in the benchmarks below) with that layer's blob ID.
+// This is the shape of the work a "trivy image" re-scan does once the DB and
+// blob cache are warm, and is what client-side caching is meant to speed up.
+func benchmarkLayerRescan(b *testing.B, get func(blobID string) (types.BlobInfo, error)) {
+	for i := 0; i < b.N; i++ {
+		for l := 0; l < 1000; l++ {
+			if _, err := get(fmt.Sprintf("blob-%d", l)); err != nil {
+				b.Fatalf("unexpected error: %s", err)
+			}
+		}
+	}
+}
+
+// BenchmarkRedisCache_Rescan1000Layers measures GetBlob against the plain
+// redisCache, where every call is a round trip to Redis.
+func BenchmarkRedisCache_Rescan1000Layers(b *testing.B) {
+	mr := miniredis.RunT(b)
+	c, _ := newTestRedisCacheForBench(b, mr)
+	seedBlobs(b, func(id string, info types.BlobInfo) error { return c.PutBlob(id, info) })
+
+	b.ResetTimer()
+	benchmarkLayerRescan(b, c.GetBlob)
+}
+
+// BenchmarkTrackingRedisCache_Rescan1000Layers measures GetBlob against
+// trackingRedisCache, where repeat reads of the same 1000 blob keys are
+// served from rueidis's client-side cache after the first pass instead of
+// round-tripping to Redis every time.
+func BenchmarkTrackingRedisCache_Rescan1000Layers(b *testing.B) {
+	mr := miniredis.RunT(b)
+	client, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{mr.Addr()}})
+	if err != nil {
+		b.Skipf("rueidis client unavailable against miniredis: %s", err)
+	}
+	b.Cleanup(client.Close)
+
+	c := newTrackingRedisCache(client, time.Minute, 0, "")
+	seedBlobs(b, func(id string, info types.BlobInfo) error { return c.PutBlob(id, info) })
+
+	// Warm the client-side cache with one pass before the timed loop, the
+	// same way a first scan would populate it before any re-scan happens.
+	benchmarkLayerRescan(b, c.GetBlob)
+
+	b.ResetTimer()
+	benchmarkLayerRescan(b, c.GetBlob)
+}
+
+func newTestRedisCacheForBench(b *testing.B, mr *miniredis.Miniredis) (*redisCache, *miniredis.Miniredis) {
+	b.Helper()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	b.Cleanup(func() { _ = client.Close() })
+	return newRedisCache(client, "", 0, 0), mr
+}
+
+func seedBlobs(b *testing.B, put func(id string, info types.BlobInfo) error) {
+	b.Helper()
+	for l := 0; l < 1000; l++ {
+		if err := put(fmt.Sprintf("blob-%d", l), types.BlobInfo{SchemaVersion: 1}); err != nil {
+			b.Fatalf("unable to seed blob: %s", err)
+		}
+	}
+}